@@ -1,19 +1,33 @@
-// go:generate go-bindata -o bindata.go template mapconfig
 package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	_ "embed"
 	"fmt"
 	"go/format"
-	"html/template"
+	"path/filepath"
 	"sort"
+	"strings"
+	"text/template"
+	"unicode"
 
 	"github.com/BurntSushi/toml"
 	"github.com/achiku/varfmt"
 	"github.com/pkg/errors"
 )
 
+// typeMap and mysqlTypeMap are the built-in type map TOMLs used by
+// defaultTypeMap when CreateStruct/Generator are called with an empty
+// typeMapPath
+//
+//go:embed mapconfig/typemap.toml
+var typeMap string
+
+//go:embed mapconfig/mysql_typemap.toml
+var mysqlTypeMap string
+
 const pgLoadColumnDef = `
 SELECT
     a.attnum AS field_ordinal,
@@ -21,7 +35,8 @@ SELECT
     format_type(a.atttypid, a.atttypmod) AS data_type,
     a.attnotnull AS not_null,
     COALESCE(pg_get_expr(ad.adbin, ad.adrelid), '') AS default_value,
-    COALESCE(ct.contype = 'p', false) AS  is_primary_key
+    COALESCE(ct.contype = 'p', false) AS  is_primary_key,
+    COALESCE(pg_catalog.col_description(c.oid, a.attnum), '') AS comment
 FROM pg_attribute a
 JOIN ONLY pg_class c ON c.oid = a.attrelid
 JOIN ONLY pg_namespace n ON n.oid = c.relnamespace
@@ -38,13 +53,59 @@ ORDER BY a.attnum
 const pgLoadTableDef = `
 SELECT
 c.relkind AS type,
-c.relname AS table_name
+c.relname AS table_name,
+COALESCE(obj_description(c.oid, 'pg_class'), '') AS comment
 FROM pg_class c
 JOIN ONLY pg_namespace n ON n.oid = c.relnamespace
 WHERE n.nspname = $1
 AND c.relkind = 'r'
 `
 
+const pgLoadForeignKeyDef = `
+SELECT
+    con.conname AS constraint_name,
+    c.relname AS table_name,
+    a.attname AS column_name,
+    fc.relname AS ref_table_name,
+    fa.attname AS ref_column_name
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_class fc ON fc.oid = con.confrelid
+JOIN LATERAL unnest(con.conkey) WITH ORDINALITY AS ck(attnum, ord) ON true
+JOIN LATERAL unnest(con.confkey) WITH ORDINALITY AS fk(attnum, ord) ON fk.ord = ck.ord
+JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = ck.attnum
+JOIN pg_attribute fa ON fa.attrelid = con.confrelid AND fa.attnum = fk.attnum
+WHERE con.contype = 'f'
+AND n.nspname = $1
+`
+
+const mysqlLoadColumnDef = `
+SELECT
+    ORDINAL_POSITION AS field_ordinal,
+    COLUMN_NAME AS column_name,
+    DATA_TYPE AS data_type,
+    COLUMN_TYPE AS column_type,
+    IS_NULLABLE = 'NO' AS not_null,
+    COALESCE(COLUMN_DEFAULT, '') AS default_value,
+    COLUMN_KEY = 'PRI' AS is_primary_key,
+    COALESCE(COLUMN_COMMENT, '') AS comment
+FROM information_schema.columns
+WHERE table_schema = ?
+AND table_name = ?
+ORDER BY ORDINAL_POSITION
+`
+
+const mysqlLoadTableDef = `
+SELECT
+'r' AS type,
+TABLE_NAME AS table_name,
+COALESCE(TABLE_COMMENT, '') AS comment
+FROM information_schema.tables
+WHERE table_schema = ?
+AND table_type = 'BASE TABLE'
+`
+
 // TypeMap go/db type map struct
 type TypeMap struct {
 	DBTypes          []string `toml:"db_types"`
@@ -59,10 +120,12 @@ type PgTypeMapConfig map[string]TypeMap
 
 // PgTable postgres table
 type PgTable struct {
-	Schema   string
-	Name     string
-	DataType string
-	Columns  []*PgColumn
+	Schema      string
+	Name        string
+	DataType    string
+	Comment     string
+	Columns     []*PgColumn
+	ForeignKeys []*PgForeignKey
 }
 
 // PgColumn postgres columns
@@ -70,9 +133,140 @@ type PgColumn struct {
 	FieldOrdinal int
 	Name         string
 	DataType     string
+	// ColumnType is MySQL's COLUMN_TYPE (e.g. "tinyint(1)"), which, unlike
+	// DATA_TYPE, retains the display width needed to distinguish a bool
+	// column from a plain tinyint. Empty for Postgres.
+	ColumnType   string
 	NotNull      bool
 	DefaultValue sql.NullString
 	IsPrimaryKey bool
+	Comment      string
+}
+
+// PgForeignKey represents a foreign key constraint on a table's column,
+// e.g. orders.user_id referencing users.id
+type PgForeignKey struct {
+	ConstraintName string
+	Table          string
+	Column         string
+	RefTable       string
+	RefColumn      string
+}
+
+// Queryer runs parameterized queries, satisfied by *sql.DB and *sql.Tx
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Execer runs the context-aware statements used by generated CRUD methods,
+// satisfied by *sql.DB and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// DbTransformer abstracts the DBMS specific pieces of struct generation:
+// loading table/column definitions and mapping a column to a Go type.
+// Implementations are registered under a driver name via RegisterDbTransformer.
+type DbTransformer interface {
+	LoadTableDef(db Queryer, schema string) ([]*PgTable, error)
+	LoadColumnDef(db Queryer, schema string, table string) ([]*PgColumn, error)
+	ConvertType(col *PgColumn, typeCfg *PgTypeMapConfig) (string, string)
+}
+
+var dbTransformers = map[string]DbTransformer{}
+
+// RegisterDbTransformer registers a DbTransformer under a driver name so
+// CreateStruct can dispatch to it
+func RegisterDbTransformer(driver string, t DbTransformer) {
+	dbTransformers[driver] = t
+}
+
+func init() {
+	RegisterDbTransformer("postgres", &PostgresDB{})
+	RegisterDbTransformer("mysql", &MysqlDB{})
+}
+
+// PostgresDB is the DbTransformer implementation for PostgreSQL
+type PostgresDB struct{}
+
+// LoadTableDef loads Postgres table definitions
+func (t *PostgresDB) LoadTableDef(db Queryer, schema string) ([]*PgTable, error) {
+	return PgLoadTableDef(db, schema)
+}
+
+// LoadColumnDef loads Postgres column definitions
+func (t *PostgresDB) LoadColumnDef(db Queryer, schema string, table string) ([]*PgColumn, error) {
+	return PgLoadColumnDef(db, schema, table)
+}
+
+// ConvertType converts a Postgres column to a Go type
+func (t *PostgresDB) ConvertType(col *PgColumn, typeCfg *PgTypeMapConfig) (string, string) {
+	return PgConvertType(col, typeCfg)
+}
+
+// MysqlDB is the DbTransformer implementation for MySQL
+type MysqlDB struct{}
+
+// LoadColumnDef loads MySQL column definitions from information_schema.columns
+func (t *MysqlDB) LoadColumnDef(db Queryer, schema string, table string) ([]*PgColumn, error) {
+	colDefs, err := db.Query(mysqlLoadColumnDef, schema, table)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load table def")
+	}
+
+	cols := []*PgColumn{}
+	for colDefs.Next() {
+		c := &PgColumn{}
+		err := colDefs.Scan(
+			&c.FieldOrdinal,
+			&c.Name,
+			&c.DataType,
+			&c.ColumnType,
+			&c.NotNull,
+			&c.DefaultValue,
+			&c.IsPrimaryKey,
+			&c.Comment,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// LoadTableDef loads MySQL table definitions from information_schema.tables
+func (t *MysqlDB) LoadTableDef(db Queryer, schema string) ([]*PgTable, error) {
+	tbDefs, err := db.Query(mysqlLoadTableDef, schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load table def")
+	}
+	tbs := []*PgTable{}
+	for tbDefs.Next() {
+		tb := &PgTable{Schema: schema}
+		err := tbDefs.Scan(
+			&tb.DataType,
+			&tb.Name,
+			&tb.Comment,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+		cols, err := t.LoadColumnDef(db, schema, tb.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to get columns of %s", tb.Name))
+		}
+		tb.Columns = cols
+		tbs = append(tbs, tb)
+	}
+	return tbs, nil
+}
+
+// ConvertType converts a MySQL column to a Go type using the same
+// DBTypes-based matching as Postgres
+func (t *MysqlDB) ConvertType(col *PgColumn, typeCfg *PgTypeMapConfig) (string, string) {
+	return PgConvertType(col, typeCfg)
 }
 
 // PgLoadTypeMapFromFile load type map from toml file
@@ -84,6 +278,48 @@ func PgLoadTypeMapFromFile(filePath string) (*PgTypeMapConfig, error) {
 	return &conf, nil
 }
 
+// TagConfig controls which struct tags PgTableToStruct emits on generated
+// fields
+type TagConfig struct {
+	DB   bool `toml:"db"`
+	JSON bool `toml:"json"`
+	Goqu bool `toml:"goqu"`
+}
+
+// defaultTagConfig emits only the sqlx-style db tag
+var defaultTagConfig = &TagConfig{DB: true}
+
+// PgLoadTagConfigFromFile loads the [tag_config] table of a type map TOML
+// file, defaulting to db-tag-only when the file has none
+func PgLoadTagConfigFromFile(filePath string) (*TagConfig, error) {
+	conf := &struct {
+		Tag TagConfig `toml:"tag_config"`
+	}{Tag: *defaultTagConfig}
+	if _, err := toml.DecodeFile(filePath, conf); err != nil {
+		return nil, errors.Wrap(err, "faild to parse config file")
+	}
+	return &conf.Tag, nil
+}
+
+// buildFieldTag renders the Go struct tag for a column per tagCfg. tagCfg
+// may be nil, in which case defaultTagConfig is used
+func buildFieldTag(colName string, tagCfg *TagConfig) string {
+	if tagCfg == nil {
+		tagCfg = defaultTagConfig
+	}
+	var parts []string
+	if tagCfg.DB {
+		parts = append(parts, fmt.Sprintf(`db:"%s"`, colName))
+	}
+	if tagCfg.JSON {
+		parts = append(parts, fmt.Sprintf(`json:"%s"`, colName))
+	}
+	if tagCfg.Goqu {
+		parts = append(parts, fmt.Sprintf(`goqu:"%s"`, colName))
+	}
+	return strings.Join(parts, " ")
+}
+
 // PgLoadColumnDef load Postgres column definition
 func PgLoadColumnDef(db Queryer, schema string, table string) ([]*PgColumn, error) {
 	colDefs, err := db.Query(pgLoadColumnDef, schema, table)
@@ -101,6 +337,7 @@ func PgLoadColumnDef(db Queryer, schema string, table string) ([]*PgColumn, erro
 			&c.NotNull,
 			&c.DefaultValue,
 			&c.IsPrimaryKey,
+			&c.Comment,
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to scan")
@@ -122,6 +359,7 @@ func PgLoadTableDef(db Queryer, schema string) ([]*PgTable, error) {
 		err := tbDefs.Scan(
 			&t.DataType,
 			&t.Name,
+			&t.Comment,
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to scan")
@@ -136,13 +374,49 @@ func PgLoadTableDef(db Queryer, schema string) ([]*PgTable, error) {
 	return tbs, nil
 }
 
+// PgLoadForeignKeyDef loads foreign key constraints for schema
+func PgLoadForeignKeyDef(db Queryer, schema string) ([]*PgForeignKey, error) {
+	fkDefs, err := db.Query(pgLoadForeignKeyDef, schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load foreign key definitions")
+	}
+	fks := []*PgForeignKey{}
+	for fkDefs.Next() {
+		fk := &PgForeignKey{}
+		err := fkDefs.Scan(
+			&fk.ConstraintName,
+			&fk.Table,
+			&fk.Column,
+			&fk.RefTable,
+			&fk.RefColumn,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+		fks = append(fks, fk)
+	}
+	return fks, nil
+}
+
+// attachForeignKeys assigns each foreign key to the PgTable it was declared on
+func attachForeignKeys(tbls []*PgTable, fks []*PgForeignKey) {
+	byTable := map[string][]*PgForeignKey{}
+	for _, fk := range fks {
+		byTable[fk.Table] = append(byTable[fk.Table], fk)
+	}
+	for _, t := range tbls {
+		t.ForeignKeys = byTable[t.Name]
+	}
+}
+
 // StructField go struct field
 type StructField struct {
-	Name   string
-	Type   string
-	Tag    string
-	NilVal string
-	Col    *PgColumn
+	Name      string
+	Type      string
+	Tag       string
+	NilVal    string
+	Col       *PgColumn
+	IsAutoKey bool
 }
 
 // Struct go struct
@@ -151,7 +425,66 @@ type Struct struct {
 	TableName string
 	Schema    string
 	Comment   string
+	Table     *PgTable
 	Fields    []*StructField
+	// InsertableFields are Fields minus any database-generated key columns
+	InsertableFields []*StructField
+	// PKFields are the primary key fields, in column order. Empty if the
+	// table has no primary key; more than one entry for a composite key.
+	PKFields []*StructField
+	// Relations are belongs-to/has-many fields derived from foreign keys
+	Relations []*StructRelation
+}
+
+// StructRelation is a belongs-to or has-many relation field derived from a
+// foreign key, e.g. `User *User` (belongs-to) or `Orders []*Order` (has-many)
+type StructRelation struct {
+	FieldName string
+	TypeName  string
+	Many      bool
+	// FKColumn is the referencing column name, rendered into the `fk:"..."`
+	// tag on belongs-to fields
+	FKColumn string
+}
+
+// singularName is a minimal heuristic for turning a plural table name into
+// a singular Go identifier for relation field types, e.g. "users" -> "User"
+func singularName(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return varfmt.PublicVarName(strings.TrimSuffix(name, "ies") + "y")
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return varfmt.PublicVarName(strings.TrimSuffix(name, "s"))
+	default:
+		return varfmt.PublicVarName(name)
+	}
+}
+
+// AutoKeyConfig lists the Postgres column types considered
+// database-generated (e.g. serial), mirroring TypeMap's db_types matching
+type AutoKeyConfig struct {
+	DBTypes []string `toml:"db_types"`
+}
+
+// AutoKeyMap maps a category name to its AutoKeyConfig
+type AutoKeyMap map[string]AutoKeyConfig
+
+// autoGenKeyCfg is the built-in default autokey map used when the caller
+// does not load one from autokey.toml
+var autoGenKeyCfg = &AutoKeyMap{
+	"serial": AutoKeyConfig{DBTypes: []string{"serial", "bigserial", "smallserial"}},
+}
+
+// IsAutoKey reports whether col's Postgres type is a database-generated key
+// type (e.g. serial) per keyCfg
+func IsAutoKey(col *PgColumn, keyCfg *AutoKeyMap) bool {
+	cfg := map[string]AutoKeyConfig(*keyCfg)
+	for _, v := range cfg {
+		if contains(col.DataType, v.DBTypes) {
+			return true
+		}
+	}
+	return false
 }
 
 func contains(v string, l []string) bool {
@@ -163,11 +496,24 @@ func contains(v string, l []string) bool {
 	return false
 }
 
-// PgConvertType converts type
+// PgConvertType converts type. For MySQL, col.ColumnType (which retains
+// display width, e.g. "tinyint(1)" for bool) is matched first since it's
+// more specific than DataType (e.g. a bool column's DataType is the same
+// "tinyint" as a plain tinyint column); DataType is used as the fallback.
 func PgConvertType(col *PgColumn, typeCfg *PgTypeMapConfig) (string, string) {
 	cfg := map[string]TypeMap(*typeCfg)
 	typ := cfg["default"].NotNullGoType
 	nilVal := cfg["default"].NotNullNilValue
+	if col.ColumnType != "" {
+		for _, v := range cfg {
+			if contains(col.ColumnType, v.DBTypes) {
+				if col.NotNull {
+					return v.NotNullGoType, v.NotNullNilValue
+				}
+				return v.NullableGoType, v.NullableNilValue
+			}
+		}
+	}
 	for _, v := range cfg {
 		if contains(col.DataType, v.DBTypes) {
 			if col.NotNull {
@@ -187,42 +533,144 @@ func PgColToField(col *PgColumn, typeCfg *PgTypeMapConfig) (*StructField, error)
 	return stf, nil
 }
 
-const structTmpl = `
-// {{ .Name }} represents {{ .Schema }}.{{ .TableName }}
-type {{ .Name }} struct {
-{{- range .Fields }}
-	{{ .Name }} {{ .Type }} // {{ .Col.Name }}
-{{- end }}
-}`
-
-// PgTableToStruct converts table def to go struct
-func PgTableToStruct(t *PgTable, typeCfg *PgTypeMapConfig) (*Struct, error) {
+// PgTableToStruct converts table def to go struct. keyCfg decides which
+// fields are database-generated keys, which are left out of InsertableFields.
+// tagCfg controls which struct tags are emitted; pass nil for the default
+// (db tag only)
+func PgTableToStruct(tbl *PgTable, typeCfg *PgTypeMapConfig, keyCfg *AutoKeyMap, tagCfg *TagConfig) (*Struct, error) {
 	s := &Struct{
-		Name:      varfmt.PublicVarName(t.Name),
-		TableName: t.Name,
-		Schema:    t.Schema,
+		Name:      varfmt.PublicVarName(tbl.Name),
+		TableName: tbl.Name,
+		Schema:    tbl.Schema,
+		Table:     tbl,
 	}
 	var fs []*StructField
-	for _, c := range t.Columns {
+	for _, c := range tbl.Columns {
 		f, err := PgColToField(c, typeCfg)
 		if err != nil {
 			return nil, errors.Wrap(err, "faield to convert col to field")
 		}
+		f.IsAutoKey = IsAutoKey(c, keyCfg)
+		f.Tag = buildFieldTag(c.Name, tagCfg)
 		fs = append(fs, f)
+		if !f.IsAutoKey {
+			s.InsertableFields = append(s.InsertableFields, f)
+		}
+		if c.IsPrimaryKey {
+			s.PKFields = append(s.PKFields, f)
+		}
 	}
 	s.Fields = fs
+	for _, fk := range tbl.ForeignKeys {
+		s.Relations = append(s.Relations, &StructRelation{
+			FieldName: varfmt.PublicVarName(strings.TrimSuffix(fk.Column, "_id")),
+			TypeName:  singularName(fk.RefTable),
+			FKColumn:  fk.Column,
+		})
+	}
 	return s, nil
 }
 
-// PgExecuteStructTmpl execute struct template with *Struct
-func PgExecuteStructTmpl(st *Struct) ([]byte, error) {
+// StructTmpl wraps a Struct for rendering through a user-suppliable template
+type StructTmpl struct {
+	Struct *Struct
+	// TemplatePath overrides the templatePath argument to
+	// PgExecuteStructTmpl when set
+	TemplatePath string
+}
+
+// pascal converts a snake_case identifier to PascalCase, e.g. "user_id" -> "UserID"
+func pascal(s string) string {
+	return varfmt.PublicVarName(s)
+}
+
+// camel converts a snake_case identifier to camelCase, e.g. "user_id" -> "userID"
+func camel(s string) string {
+	if s == "" {
+		return s
+	}
+	p := pascal(s)
+	if p == "" {
+		return p
+	}
+	return strings.ToLower(p[:1]) + p[1:]
+}
+
+// snake converts a PascalCase/camelCase identifier to snake_case, e.g.
+// "UserName" -> "user_name". Every upper-case letter starts a new word, so
+// initialisms are split letter by letter ("UserID" -> "user_i_d")
+func snake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// pluralize applies a naive English pluralization heuristic, e.g. "order" -> "orders"
+func pluralize(s string) string {
+	switch {
+	case strings.HasSuffix(s, "y") && len(s) > 1 && !strings.ContainsRune("aeiou", rune(s[len(s)-2])):
+		return s[:len(s)-1] + "ies"
+	case strings.HasSuffix(s, "s"), strings.HasSuffix(s, "x"), strings.HasSuffix(s, "z"),
+		strings.HasSuffix(s, "ch"), strings.HasSuffix(s, "sh"):
+		return s + "es"
+	default:
+		return s + "s"
+	}
+}
+
+// hasPK reports whether s has a primary key
+func hasPK(s *Struct) bool {
+	return len(s.PKFields) > 0
+}
+
+// add returns a + b; a template helper for computing placeholder positions
+func add(a, b int) int {
+	return a + b
+}
+
+// nonPKColumns returns s's fields that are not part of the primary key
+func nonPKColumns(s *Struct) []*StructField {
+	fs := make([]*StructField, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		if !f.Col.IsPrimaryKey {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// tmplFuncs are helper funcs available to templates executed by
+// PgExecuteStructTmpl and Generator
+var tmplFuncs = template.FuncMap{
+	"inc":          func(i int) int { return i + 1 },
+	"pascal":       pascal,
+	"camel":        camel,
+	"snake":        snake,
+	"pluralize":    pluralize,
+	"hasPK":        hasPK,
+	"nonPKColumns": nonPKColumns,
+	"add":          add,
+}
+
+// executeTemplateFile parses and executes the template at path against data,
+// producing gofmt'd source
+func executeTemplateFile(path string, data interface{}) ([]byte, error) {
 	var src []byte
-	tpl, err := template.New("struct").Parse(structTmpl)
+	tpl, err := template.New(filepath.Base(path)).Funcs(tmplFuncs).ParseFiles(path)
 	if err != nil {
 		return src, errors.Wrap(err, "failed to parse template")
 	}
 	buf := new(bytes.Buffer)
-	if err := tpl.Execute(buf, st); err != nil {
+	if err := tpl.Execute(buf, data); err != nil {
 		return src, errors.Wrap(err, "failed to execute template")
 	}
 	src, err = format.Source(buf.Bytes())
@@ -232,33 +680,216 @@ func PgExecuteStructTmpl(st *Struct) ([]byte, error) {
 	return src, nil
 }
 
-// PgCreateStruct creates struct from given schema
-func PgCreateStruct(db Queryer, schema, typeMapPath string) ([]byte, error) {
-	var src []byte
-	tbls, err := PgLoadTableDef(db, schema)
+// PgExecuteStructTmpl executes the template at templatePath (or
+// st.TemplatePath, if set) against st, producing gofmt'd source
+func PgExecuteStructTmpl(st *StructTmpl, templatePath string) ([]byte, error) {
+	path := templatePath
+	if st.TemplatePath != "" {
+		path = st.TemplatePath
+	}
+	return executeTemplateFile(path, st)
+}
+
+// TemplateData is the data exposed to templates rendered by Generator. It
+// offers the same .Struct field as StructTmpl plus direct access to the
+// loaded table and relation data
+type TemplateData struct {
+	Struct      *Struct
+	Table       *PgTable
+	ForeignKeys []*PgForeignKey
+	AutoKey     *AutoKeyMap
+}
+
+// Generator renders every *.tmpl file in a template directory against each
+// table in a schema, producing one output per table that concatenates that
+// table's rendered templates in file-name order (so method.tmpl comes
+// before struct.tmpl). It is the pluggable alternative to CreateStruct's
+// built-in struct/method generation: pass a custom TemplateDir to override
+// any of the built-in templates
+type Generator struct {
+	TemplateDir string
+}
+
+// NewGenerator creates a Generator that renders the templates in
+// templateDir. An empty templateDir uses the built-in template/ directory
+func NewGenerator(templateDir string) *Generator {
+	if templateDir == "" {
+		templateDir = "template"
+	}
+	return &Generator{TemplateDir: templateDir}
+}
+
+// Generate renders every *.tmpl file in g.TemplateDir against each table in
+// schema, returning the gofmt'd, concatenated source for each table keyed
+// by table name (e.g. "orders"). Like CreateStructWithOptions, the
+// returned source has no package clause or import block; callers are
+// expected to wrap each table's source with their own before writing it
+// out as a .go file.
+func (g *Generator) Generate(driver string, db Queryer, schema, typeMapPath string, opts *PgCreateStructOptions) (map[string][]byte, error) {
+	if opts == nil {
+		opts = &PgCreateStructOptions{}
+	}
+	sts, err := loadStructs(driver, db, schema, typeMapPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	tmplPaths, err := filepath.Glob(filepath.Join(g.TemplateDir, "*.tmpl"))
+	if err != nil {
+		return nil, errors.Wrap(err, "faield to list templates")
+	}
+	sort.Strings(tmplPaths)
+	out := map[string][]byte{}
+	for _, st := range sts {
+		data := &TemplateData{
+			Struct:      st,
+			Table:       st.Table,
+			ForeignKeys: st.Table.ForeignKeys,
+			AutoKey:     autoGenKeyCfg,
+		}
+		var src []byte
+		for _, tmplPath := range tmplPaths {
+			s, err := executeTemplateFile(tmplPath, data)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("faield to execute template %s", tmplPath))
+			}
+			src = append(src, s...)
+		}
+		out[st.TableName] = src
+	}
+	return out, nil
+}
+
+// defaultTypeMap returns the driver's built-in type map TOML, falling back
+// to the Postgres one for drivers that don't ship their own
+func defaultTypeMap(driver string) string {
+	if driver == "mysql" {
+		return mysqlTypeMap
+	}
+	return typeMap
+}
+
+// PgCreateStructOptions configures optional CreateStruct behavior
+type PgCreateStructOptions struct {
+	// LoadRelations discovers foreign keys (Postgres only) and embeds
+	// belongs-to/has-many relation fields on the generated structs
+	LoadRelations bool
+}
+
+// loadStructs loads schema's tables for driver, optionally discovering
+// relations, and converts them to Structs using typeMapPath (or driver's
+// built-in type map when empty). It is the shared first stage of both
+// CreateStructWithOptions and Generator.Generate.
+func loadStructs(driver string, db Queryer, schema, typeMapPath string, opts *PgCreateStructOptions) ([]*Struct, error) {
+	t, ok := dbTransformers[driver]
+	if !ok {
+		return nil, errors.Errorf("no DbTransformer registered for driver %s", driver)
+	}
+	tbls, err := t.LoadTableDef(db, schema)
 	if err != nil {
-		return src, errors.Wrap(err, "faield to load table definitions")
+		return nil, errors.Wrap(err, "faield to load table definitions")
+	}
+	if opts.LoadRelations {
+		fks, err := PgLoadForeignKeyDef(db, schema)
+		if err != nil {
+			return nil, errors.Wrap(err, "faield to load foreign key definitions")
+		}
+		attachForeignKeys(tbls, fks)
 	}
 	cfg := &PgTypeMapConfig{}
+	tagCfg := defaultTagConfig
 	if typeMapPath == "" {
-		if _, err := toml.Decode(typeMap, cfg); err != nil {
-			return src, errors.Wrap(err, "faield to read type map")
+		if _, err := toml.Decode(defaultTypeMap(driver), cfg); err != nil {
+			return nil, errors.Wrap(err, "faield to read type map")
 		}
 	} else {
 		if _, err := toml.DecodeFile(typeMapPath, cfg); err != nil {
-			return src, errors.Wrap(err, fmt.Sprintf("failed to decode type map file %s", typeMapPath))
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to decode type map file %s", typeMapPath))
+		}
+		tc, err := PgLoadTagConfigFromFile(typeMapPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "faield to read tag config")
 		}
+		tagCfg = tc
 	}
+	sts := make([]*Struct, 0, len(tbls))
+	byTable := map[string]*Struct{}
 	for _, tbl := range tbls {
-		st, err := PgTableToStruct(tbl, cfg)
+		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg, tagCfg)
 		if err != nil {
-			return src, errors.Wrap(err, "faield to convert table definition to struct")
+			return nil, errors.Wrap(err, "faield to convert table definition to struct")
 		}
-		s, err := PgExecuteStructTmpl(st)
+		sts = append(sts, st)
+		byTable[tbl.Name] = st
+	}
+	if opts.LoadRelations {
+		attachHasManyRelations(sts, byTable)
+	}
+	return sts, nil
+}
+
+// attachHasManyRelations adds a has-many relation field to each struct whose
+// table is referenced by another table's foreign key
+func attachHasManyRelations(sts []*Struct, byTable map[string]*Struct) {
+	for _, st := range sts {
+		for _, fk := range st.Table.ForeignKeys {
+			parent, ok := byTable[fk.RefTable]
+			if !ok {
+				continue
+			}
+			typeName := singularName(st.Table.Name)
+			parent.Relations = append(parent.Relations, &StructRelation{
+				FieldName: pluralize(typeName),
+				TypeName:  typeName,
+				Many:      true,
+			})
+		}
+	}
+}
+
+// CreateStruct creates struct (and CRUD method) source for the given schema
+// via CreateStructWithOptions. Only driver "postgres" is accepted; any other
+// driver, including "mysql", returns an error, and MySQL structs are
+// generated through Generator instead
+func CreateStruct(driver string, db Queryer, schema, typeMapPath string) ([]byte, error) {
+	return CreateStructWithOptions(driver, db, schema, typeMapPath, nil)
+}
+
+// CreateStructWithOptions creates struct (and CRUD method) source for the
+// given schema, using opts to control optional behavior such as relation
+// discovery. A nil opts behaves like &PgCreateStructOptions{}. CRUD method
+// generation (template/method.tmpl) emits Postgres-specific SQL (positional
+// $N placeholders, RETURNING), so driver must be "postgres"; other drivers
+// should use Generator with their own method template instead.
+func CreateStructWithOptions(driver string, db Queryer, schema, typeMapPath string, opts *PgCreateStructOptions) ([]byte, error) {
+	var src []byte
+	if opts == nil {
+		opts = &PgCreateStructOptions{}
+	}
+	if driver != "postgres" {
+		return src, errors.Errorf("CreateStructWithOptions only supports CRUD method generation for driver \"postgres\" (template/method.tmpl emits Postgres-specific SQL), got %q", driver)
+	}
+	sts, err := loadStructs(driver, db, schema, typeMapPath, opts)
+	if err != nil {
+		return src, err
+	}
+	for _, st := range sts {
+		stTmpl := &StructTmpl{Struct: st}
+		s, err := PgExecuteStructTmpl(stTmpl, "template/struct.tmpl")
 		if err != nil {
 			return src, errors.Wrap(err, "faield to execute template")
 		}
 		src = append(src, s...)
+		m, err := PgExecuteStructTmpl(stTmpl, "template/method.tmpl")
+		if err != nil {
+			return src, errors.Wrap(err, "faield to execute template")
+		}
+		src = append(src, m...)
 	}
 	return src, nil
 }
+
+// PgCreateStruct creates struct from given schema (Postgres only; kept for
+// callers that haven't moved to CreateStruct's driver-aware signature)
+func PgCreateStruct(db Queryer, schema, typeMapPath string) ([]byte, error) {
+	return CreateStruct("postgres", db, schema, typeMapPath)
+}
@@ -3,12 +3,25 @@ package main
 import (
 	"database/sql"
 	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
 	_ "github.com/lib/pq"
 )
 
+// fixtureTypeMap decodes the built-in Postgres type map for tests that
+// build PgTable fixtures by hand instead of hitting a live database
+func fixtureTypeMap(t *testing.T) *PgTypeMapConfig {
+	cfg := &PgTypeMapConfig{}
+	if _, err := toml.Decode(defaultTypeMap("postgres"), cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
 // before running test, create user and database
 // CREATE USER dgw_test;
 // CREATE DATABASE  dgw_test OWNER dgw_test;
@@ -50,7 +63,7 @@ func testSetupStruct(t *testing.T, conn *sql.DB) []*Struct {
 
 	var sts []*Struct
 	for _, tbl := range tbls {
-		st, err := PgTableToStruct(tbl, cfg, keyCfg)
+		st, err := PgTableToStruct(tbl, cfg, keyCfg, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -124,6 +137,397 @@ func TestPgLoadTypeMap(t *testing.T) {
 	}
 }
 
+func TestDefaultTypeMap(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql"} {
+		cfg := &PgTypeMapConfig{}
+		if _, err := toml.Decode(defaultTypeMap(driver), cfg); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if len(*cfg) == 0 {
+			t.Fatalf("%s: embedded type map decoded empty", driver)
+		}
+	}
+}
+
+func TestPgConvertTypeMysqlBool(t *testing.T) {
+	cfg := &PgTypeMapConfig{}
+	if _, err := toml.Decode(mysqlTypeMap, cfg); err != nil {
+		t.Fatal(err)
+	}
+	boolCol := &PgColumn{DataType: "tinyint", ColumnType: "tinyint(1)", NotNull: true}
+	if typ, _ := PgConvertType(boolCol, cfg); typ != "bool" {
+		t.Fatalf("expected tinyint(1) to convert to bool, got %s", typ)
+	}
+	intCol := &PgColumn{DataType: "tinyint", ColumnType: "tinyint(4)", NotNull: true}
+	if typ, _ := PgConvertType(intCol, cfg); typ != "int" {
+		t.Fatalf("expected plain tinyint to convert to int, got %s", typ)
+	}
+}
+
+func TestPgTableToMethodCompositePK(t *testing.T) {
+	tbl := &PgTable{
+		Schema: "public",
+		Name:   "order_items",
+		Columns: []*PgColumn{
+			{Name: "order_id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+			{Name: "product_id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+			{Name: "quantity", DataType: "integer", NotNull: true},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.PKFields) != 2 {
+		t.Fatalf("expected 2 PK fields, got %d", len(st.PKFields))
+	}
+	src, err := PgExecuteStructTmpl(&StructTmpl{Struct: st}, "template/method.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	if !strings.Contains(out, "order_id = $") || !strings.Contains(out, "product_id = $") {
+		t.Fatalf("expected Update/Delete WHERE clauses to constrain both PK columns, got:\n%s", out)
+	}
+	if !strings.Contains(out, "order_id = $1 AND product_id = $2") {
+		t.Fatalf("expected Delete's WHERE clause to AND both PK columns, got:\n%s", out)
+	}
+	if !strings.Contains(out, "SET quantity = $1") {
+		t.Fatalf("expected Update's SET clause to only assign non-PK columns, got:\n%s", out)
+	}
+	if !strings.Contains(out, "order_id = $2 AND product_id = $3") {
+		t.Fatalf("expected Update's WHERE clause to AND both PK columns with offset placeholders, got:\n%s", out)
+	}
+}
+
+func TestPgTableToMethodNoPK(t *testing.T) {
+	tbl := &PgTable{
+		Schema: "public",
+		Name:   "audit_log",
+		Columns: []*PgColumn{
+			{Name: "event", DataType: "text", NotNull: true},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := PgExecuteStructTmpl(&StructTmpl{Struct: st}, "template/method.tmpl")
+	if err != nil {
+		t.Fatalf("expected PK-less table to render without error, got: %v", err)
+	}
+	out := string(src)
+	if strings.Contains(out, "Update") || strings.Contains(out, "Delete") || strings.Contains(out, "GetByPrimaryKey") {
+		t.Fatalf("expected no Update/Delete/GetByPrimaryKey for a PK-less table, got:\n%s", out)
+	}
+}
+
+func TestTemplateHelperFuncs(t *testing.T) {
+	if got, want := pascal("user_id"), "UserID"; got != want {
+		t.Errorf("pascal(%q) = %q, want %q", "user_id", got, want)
+	}
+	if got, want := camel("user_id"), "userID"; got != want {
+		t.Errorf("camel(%q) = %q, want %q", "user_id", got, want)
+	}
+	if got, want := camel(""), ""; got != want {
+		t.Errorf("camel(%q) = %q, want %q", "", got, want)
+	}
+	if got, want := snake("UserID"), "user_i_d"; got != want {
+		t.Errorf("snake(%q) = %q, want %q", "UserID", got, want)
+	}
+	for in, want := range map[string]string{
+		"order":    "orders",
+		"category": "categories",
+		"box":      "boxes",
+	} {
+		if got := pluralize(in); got != want {
+			t.Errorf("pluralize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHasPKAndNonPKColumns(t *testing.T) {
+	id := &StructField{Name: "Id", Col: &PgColumn{Name: "id", IsPrimaryKey: true}}
+	name := &StructField{Name: "Name", Col: &PgColumn{Name: "name"}}
+
+	withPK := &Struct{Fields: []*StructField{id, name}, PKFields: []*StructField{id}}
+	if !hasPK(withPK) {
+		t.Error("expected hasPK to be true when PKFields is non-empty")
+	}
+	cols := nonPKColumns(withPK)
+	if len(cols) != 1 || cols[0] != name {
+		t.Errorf("expected nonPKColumns to return only the non-PK field, got %+v", cols)
+	}
+
+	withoutPK := &Struct{Fields: []*StructField{name}}
+	if hasPK(withoutPK) {
+		t.Error("expected hasPK to be false when PKFields is empty")
+	}
+}
+
+func TestPgTableToStructRendersComments(t *testing.T) {
+	tbl := &PgTable{
+		Schema:  "public",
+		Name:    "users",
+		Comment: "application users",
+		Columns: []*PgColumn{
+			{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+			{Name: "email", DataType: "text", NotNull: true, Comment: "login email address"},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := PgExecuteStructTmpl(&StructTmpl{Struct: st}, "template/struct.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	if !strings.Contains(out, "// application users") {
+		t.Errorf("expected table comment in generated doc comment, got:\n%s", out)
+	}
+	if !strings.Contains(out, "// login email address") {
+		t.Errorf("expected column comment in generated doc comment, got:\n%s", out)
+	}
+}
+
+// TestPgTableToStructRendersCommentsWithSpecialChars guards against
+// regressing to html/template, which HTML-entity-escapes punctuation
+// such as & and ' in doc comments.
+func TestPgTableToStructRendersCommentsWithSpecialChars(t *testing.T) {
+	tbl := &PgTable{
+		Schema:  "public",
+		Name:    "users",
+		Comment: "Tom & Jerry's data",
+		Columns: []*PgColumn{
+			{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := PgExecuteStructTmpl(&StructTmpl{Struct: st}, "template/struct.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	if !strings.Contains(out, "// Tom & Jerry's data") {
+		t.Fatalf("expected unescaped comment punctuation, got:\n%s", out)
+	}
+	if strings.Contains(out, "&amp;") || strings.Contains(out, "&#39;") || strings.Contains(out, "&#34;") {
+		t.Fatalf("expected no HTML-escaped output, got:\n%s", out)
+	}
+}
+
+func TestBuildFieldTag(t *testing.T) {
+	if got, want := buildFieldTag("user_id", nil), `db:"user_id"`; got != want {
+		t.Errorf("buildFieldTag with nil tagCfg = %q, want %q", got, want)
+	}
+	all := &TagConfig{DB: true, JSON: true, Goqu: true}
+	if got, want := buildFieldTag("user_id", all), `db:"user_id" json:"user_id" goqu:"user_id"`; got != want {
+		t.Errorf("buildFieldTag with all tags = %q, want %q", got, want)
+	}
+	jsonOnly := &TagConfig{JSON: true}
+	if got, want := buildFieldTag("user_id", jsonOnly), `json:"user_id"`; got != want {
+		t.Errorf("buildFieldTag with json-only tagCfg = %q, want %q", got, want)
+	}
+	none := &TagConfig{}
+	if got, want := buildFieldTag("user_id", none), ``; got != want {
+		t.Errorf("buildFieldTag with empty tagCfg = %q, want %q", got, want)
+	}
+}
+
+// TestPgTableToStructRendersFieldTag guards against regressing to
+// html/template, which HTML-escapes the quotes in struct tags and
+// produces invalid Go source (e.g. `db:&#34;id&#34;`).
+func TestPgTableToStructRendersFieldTag(t *testing.T) {
+	tbl := &PgTable{
+		Schema: "public",
+		Name:   "users",
+		Columns: []*PgColumn{
+			{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := PgExecuteStructTmpl(&StructTmpl{Struct: st}, "template/struct.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	if !strings.Contains(out, `db:"id"`) {
+		t.Fatalf("expected literal unescaped struct tag db:\"id\", got:\n%s", out)
+	}
+	if strings.Contains(out, "&#34;") || strings.Contains(out, "&amp;") {
+		t.Fatalf("expected no HTML-escaped output, got:\n%s", out)
+	}
+}
+
+// TestGenerateRendersAllBuiltinTemplates renders every built-in *.tmpl file
+// against one fixture Struct, the same way Generator.Generate does, and
+// checks the concatenated output contains the symbols each template is
+// responsible for emitting.
+func TestGenerateRendersAllBuiltinTemplates(t *testing.T) {
+	tbl := &PgTable{
+		Schema: "public",
+		Name:   "orders",
+		Columns: []*PgColumn{
+			{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+			{Name: "total", DataType: "integer", NotNull: true},
+		},
+	}
+	st, err := PgTableToStruct(tbl, fixtureTypeMap(t), autoGenKeyCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &TemplateData{Struct: st, Table: tbl}
+
+	tmplPaths, err := filepath.Glob(filepath.Join("template", "*.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tmplPaths)
+	if len(tmplPaths) != 4 {
+		t.Fatalf("expected 4 built-in templates, got %d: %v", len(tmplPaths), tmplPaths)
+	}
+
+	var out []byte
+	for _, path := range tmplPaths {
+		src, err := executeTemplateFile(path, data)
+		if err != nil {
+			t.Fatalf("rendering %s: %v", path, err)
+		}
+		out = append(out, src...)
+	}
+
+	want := []string{
+		"type Orders struct",
+		"func (r *Orders) Insert(",
+		"func (r *Orders) Update(",
+		"func (r *Orders) Delete(",
+		"type OrdersRepository struct",
+		"func NewOrdersRepository(",
+		"type MockOrdersRepository struct",
+	}
+	for _, sym := range want {
+		if !strings.Contains(string(out), sym) {
+			t.Errorf("expected generated output to contain %q, got:\n%s", sym, out)
+		}
+	}
+}
+
+func TestSingularName(t *testing.T) {
+	cases := map[string]string{
+		"users":      "User",
+		"orders":     "Order",
+		"categories": "Category",
+	}
+	for in, want := range cases {
+		if got := singularName(in); got != want {
+			t.Errorf("singularName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAttachForeignKeysAndHasMany(t *testing.T) {
+	usersTbl := &PgTable{Schema: "public", Name: "users", Columns: []*PgColumn{
+		{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+	}}
+	ordersTbl := &PgTable{Schema: "public", Name: "orders", Columns: []*PgColumn{
+		{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+		{Name: "user_id", DataType: "integer", NotNull: true},
+	}}
+	tbls := []*PgTable{usersTbl, ordersTbl}
+	fks := []*PgForeignKey{
+		{ConstraintName: "orders_user_id_fkey", Table: "orders", Column: "user_id", RefTable: "users", RefColumn: "id"},
+	}
+	attachForeignKeys(tbls, fks)
+	if len(ordersTbl.ForeignKeys) != 1 {
+		t.Fatalf("expected orders to have 1 foreign key, got %d", len(ordersTbl.ForeignKeys))
+	}
+	if len(usersTbl.ForeignKeys) != 0 {
+		t.Fatalf("expected users to have no foreign keys, got %d", len(usersTbl.ForeignKeys))
+	}
+
+	cfg := fixtureTypeMap(t)
+	sts := make([]*Struct, 0, len(tbls))
+	byTable := map[string]*Struct{}
+	for _, tbl := range tbls {
+		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sts = append(sts, st)
+		byTable[tbl.Name] = st
+	}
+	attachHasManyRelations(sts, byTable)
+
+	ordersSt := byTable["orders"]
+	if len(ordersSt.Relations) != 1 || ordersSt.Relations[0].Many {
+		t.Fatalf("expected orders to have 1 belongs-to relation, got %+v", ordersSt.Relations)
+	}
+	if ordersSt.Relations[0].TypeName != "User" {
+		t.Fatalf("expected belongs-to relation type User, got %s", ordersSt.Relations[0].TypeName)
+	}
+
+	usersSt := byTable["users"]
+	if len(usersSt.Relations) != 1 || !usersSt.Relations[0].Many {
+		t.Fatalf("expected users to have 1 has-many relation, got %+v", usersSt.Relations)
+	}
+	if usersSt.Relations[0].TypeName != "Order" {
+		t.Fatalf("expected has-many relation type Order, got %s", usersSt.Relations[0].TypeName)
+	}
+	if usersSt.Relations[0].FieldName != "Orders" {
+		t.Fatalf("expected has-many relation field Orders, got %s", usersSt.Relations[0].FieldName)
+	}
+}
+
+// TestAttachHasManyRelationsNonPluralTable verifies the has-many field name
+// is derived via pluralize rather than taken from the child table's name
+// verbatim, for a child table whose name isn't already plural.
+func TestAttachHasManyRelationsNonPluralTable(t *testing.T) {
+	companiesTbl := &PgTable{Schema: "public", Name: "companies", Columns: []*PgColumn{
+		{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+	}}
+	detailTbl := &PgTable{Schema: "public", Name: "order_detail", Columns: []*PgColumn{
+		{Name: "id", DataType: "integer", NotNull: true, IsPrimaryKey: true},
+		{Name: "company_id", DataType: "integer", NotNull: true},
+	}}
+	tbls := []*PgTable{companiesTbl, detailTbl}
+	fks := []*PgForeignKey{
+		{ConstraintName: "order_detail_company_id_fkey", Table: "order_detail", Column: "company_id", RefTable: "companies", RefColumn: "id"},
+	}
+	attachForeignKeys(tbls, fks)
+
+	cfg := fixtureTypeMap(t)
+	sts := make([]*Struct, 0, len(tbls))
+	byTable := map[string]*Struct{}
+	for _, tbl := range tbls {
+		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sts = append(sts, st)
+		byTable[tbl.Name] = st
+	}
+	attachHasManyRelations(sts, byTable)
+
+	companiesSt := byTable["companies"]
+	if len(companiesSt.Relations) != 1 || !companiesSt.Relations[0].Many {
+		t.Fatalf("expected companies to have 1 has-many relation, got %+v", companiesSt.Relations)
+	}
+	if companiesSt.Relations[0].TypeName != "OrderDetail" {
+		t.Fatalf("expected has-many relation type OrderDetail, got %s", companiesSt.Relations[0].TypeName)
+	}
+	if companiesSt.Relations[0].FieldName != "OrderDetails" {
+		t.Fatalf("expected has-many relation field OrderDetails, got %s", companiesSt.Relations[0].FieldName)
+	}
+}
+
 func TestPgTableToStruct(t *testing.T) {
 	conn, cleanup := testPgSetup(t)
 	defer cleanup()
@@ -139,7 +543,7 @@ func TestPgTableToStruct(t *testing.T) {
 		t.Fatal(err)
 	}
 	for _, tbl := range tbls {
-		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg)
+		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -167,7 +571,7 @@ func TestPgTableToMethod(t *testing.T) {
 		t.Fatal(err)
 	}
 	for _, tbl := range tbls {
-		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg)
+		st, err := PgTableToStruct(tbl, cfg, autoGenKeyCfg, nil)
 		if err != nil {
 			t.Fatal(err)
 		}